@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+)
+
+// GenCtx is what a PageGenerator receives: the fully-built page tree
+// (so e.g. an "all source files" index can walk every Page.SourceFiles)
+// plus Emit to register the synthetic pages it wants added to the book.
+type GenCtx struct {
+	Book *Book
+	Root *Page
+
+	emitted []emittedPage
+}
+
+type emittedPage struct {
+	parentPath string
+	page       *Page
+}
+
+// Emit registers p as a synthetic child of the page at parentPath, a
+// "/"-joined path of page titles from the root (e.g. "go/basic-types"),
+// the same shape PageGenerator authors already think in when they want
+// to place a page next to a particular section. Pass "" to add p as a
+// direct child of the book's root page.
+func (ctx *GenCtx) Emit(parentPath string, p *Page) {
+	ctx.emitted = append(ctx.emitted, emittedPage{parentPath, p})
+}
+
+// PageGenerator emits synthetic pages at build time: a glossary, a
+// "every code sample" index, a tag page per Search term, and so on. A
+// generator runs once per book, after the real Notion page tree has
+// been fully built, so it can inspect every page before deciding what
+// to generate.
+type PageGenerator func(ctx *GenCtx) ([]*Page, error)
+
+// runPageGenerators runs every generator registered on book, grafting
+// whatever they emit onto the tree at the requested parent path. A
+// generator can return its pages directly (as children of the root) or
+// call ctx.Emit for finer placement; both end up going through the
+// same Emit queue.
+func runPageGenerators(book *Book) error {
+	ctx := &GenCtx{Book: book, Root: book.RootPage}
+	byPath := indexPagesByLogicalPath(book.RootPage, "")
+
+	for _, gen := range book.PageGenerators {
+		direct, err := gen(ctx)
+		if err != nil {
+			return fmt.Errorf("running page generator: %w", err)
+		}
+		for _, p := range direct {
+			ctx.Emit("", p)
+		}
+	}
+
+	for _, e := range ctx.emitted {
+		parent := byPath[e.parentPath]
+		if parent == nil {
+			fmt.Printf("Page generator emitted '%s' for unknown parent path '%s', adding to the book root instead\n", e.page.Title, e.parentPath)
+			parent = book.RootPage
+		}
+		logPath := logicalPath(e.parentPath, e.page.Title)
+		e.page.Parent = parent
+		if e.page.NotionID == "" {
+			// derived from the logical path, not random, so an
+			// incremental cache keyed by NotionID stays valid as long
+			// as the generator keeps producing the same page in the
+			// same place.
+			e.page.NotionID = syntheticNotionID(logPath)
+		}
+		parent.Pages = append(parent.Pages, e.page)
+		byPath[logPath] = e.page
+	}
+
+	populateSiblings(book.RootPage)
+	return nil
+}
+
+// populateSiblings sets Siblings/IsCurrent on every page under root, so
+// a TOC template can loop over p.Siblings and highlight p via
+// IsCurrent. It walks the same Page.Pages tree bookPageFromNotionPage
+// builds and runPageGenerators grafts onto, so it has to run after
+// generators have finished adding their pages - otherwise a synthetic
+// page would be missing from its siblings' Siblings slices, and
+// wouldn't get one of its own.
+func populateSiblings(root *Page) {
+	var walk func(pages []*Page)
+	walk = func(pages []*Page) {
+		for _, p := range pages {
+			p.Siblings = make([]Page, len(pages))
+			for i, sibling := range pages {
+				p.Siblings[i] = *sibling
+				p.Siblings[i].IsCurrent = sibling == p
+			}
+			walk(p.Pages)
+		}
+	}
+	walk(root.Pages)
+}
+
+// indexPagesByLogicalPath walks the page tree and returns a lookup
+// from logical path to the Page at that path, so Emit can graft a
+// synthetic page under any existing page by a stable name instead of a
+// pointer the generator couldn't otherwise have gotten hold of.
+func indexPagesByLogicalPath(root *Page, rootPath string) map[string]*Page {
+	idx := map[string]*Page{}
+	var walk func(p *Page, logPath string)
+	walk = func(p *Page, logPath string) {
+		idx[logPath] = p
+		for _, sub := range p.Pages {
+			walk(sub, logicalPath(logPath, sub.Title))
+		}
+	}
+	walk(root, rootPath)
+	return idx
+}
+
+func logicalPath(parentPath, title string) string {
+	return path.Join(parentPath, title)
+}
+
+// syntheticNotionID derives a stable identifier ("synthetic-" followed
+// by 16 hex digits of a SHA-256 hash) from a synthetic page's logical
+// path, so anything keyed by NotionID (e.g.
+// an incremental render cache) keeps working across rebuilds even
+// though the page was never assigned a real id by Notion.
+func syntheticNotionID(logPath string) string {
+	h := sha256.Sum256([]byte("synthetic:" + logPath))
+	return "synthetic-" + hex.EncodeToString(h[:8])
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/kjk/notionapi"
+	"github.com/mitchellh/mapstructure"
+	"gopkg.in/yaml.v2"
+)
+
+// PageMeta is the structured, decoded form of a page's front matter.
+// It's filled either by decoding a leading fenced code block (see
+// extractFrontMatter) or incrementally from legacy `$key: value` lines
+// (see mergeLegacyMetaValue), so both paths end up populating the same
+// struct.
+type PageMeta struct {
+	ID        string    `mapstructure:"id"`
+	SOID      string    `mapstructure:"soid"`
+	Search    []string  `mapstructure:"search"`
+	Redirects []string  `mapstructure:"redirects"`
+	Draft     bool      `mapstructure:"draft"`
+	Weight    int       `mapstructure:"weight"`
+	Aliases   []string  `mapstructure:"aliases"`
+	Date      time.Time `mapstructure:"date"`
+
+	// Params holds front-matter keys that don't map to a known field
+	// above, so an unrecognized key never fails the build.
+	Params map[string]interface{} `mapstructure:"-"`
+}
+
+var knownMetaKeys = map[string]bool{
+	"id": true, "soid": true, "search": true, "redirects": true,
+	"draft": true, "weight": true, "aliases": true, "date": true,
+}
+
+// frontMatterDecoders maps a fenced code block's language to the
+// function that turns its body into a generic map we can then weak-decode
+// into PageMeta.
+var frontMatterDecoders = map[string]func([]byte, interface{}) error{
+	"yaml": yaml.Unmarshal,
+	"toml": toml.Unmarshal,
+	"json": json.Unmarshal,
+}
+
+// extractFrontMatter looks for a leading fenced code block (```yaml,
+// ```toml or ```json) on the page and, if found, decodes it into p.Meta
+// and removes the block so it doesn't also render as page content.
+// Returns true if front matter was found and consumed.
+func extractFrontMatter(p *Page) bool {
+	page := p.NotionPage
+	if len(page.Root.Content) == 0 {
+		return false
+	}
+	block := page.Root.Content[0]
+	if block == nil || block.Type != notionapi.BlockCode {
+		return false
+	}
+	lang := strings.ToLower(strings.TrimSpace(block.CodeLanguage))
+	decode, ok := frontMatterDecoders[lang]
+	if !ok {
+		return false
+	}
+	var raw map[string]interface{}
+	if err := decode([]byte(block.Code), &raw); err != nil {
+		fmt.Printf("Failed to decode %s front matter in page %s: %s\n", lang, normalizeID(page.ID), err)
+		return false
+	}
+	var meta PageMeta
+	if err := decodeWeakMeta(raw, &meta); err != nil {
+		// a typo in one page's front matter (e.g. a `date` that isn't
+		// in a format we recognize) shouldn't take down the whole
+		// build, so leave this page's meta unset and fall back to
+		// treating the block as ordinary content, same as a syntax
+		// error above.
+		fmt.Printf("Failed to decode %s front matter in page %s: %s\n", lang, normalizeID(page.ID), err)
+		return false
+	}
+	p.Meta = meta
+	removeBlocks(page, map[int]bool{0: true})
+	return true
+}
+
+// dateLayouts are the date/time formats decodeWeakMeta accepts for the
+// `date` field, tried in order. RFC3339 covers a full timestamp;
+// "2006-01-02" covers the far more common case of an author writing a
+// bare date like `2023-01-15`.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02",
+	"2006-01-02T15:04:05",
+}
+
+// stringToFlexibleTimeHook is a mapstructure.DecodeHookFunc that tries
+// each of dateLayouts in turn, so a bare date doesn't fail decoding the
+// way mapstructure.StringToTimeHookFunc(time.RFC3339) alone would.
+func stringToFlexibleTimeHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String || to != reflect.TypeOf(time.Time{}) {
+		return data, nil
+	}
+	s := data.(string)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	var lastErr error
+	for _, layout := range dateLayouts {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("unrecognized date %q: %w", s, lastErr)
+}
+
+// decodeWeakMeta weak-decodes raw (as produced by a yaml/toml/json
+// unmarshal into map[string]interface{}) into meta, so e.g. a TOML
+// int64 weight or a bare string date still land in the right typed
+// field. Keys that aren't a known PageMeta field are collected into
+// meta.Params instead of causing an error. Returns an error instead of
+// panicking when a value can't be weakly converted to its declared
+// field's type, so the caller can treat it the same as any other
+// malformed front matter.
+func decodeWeakMeta(raw map[string]interface{}, meta *PageMeta) error {
+	if meta.Params == nil {
+		meta.Params = map[string]interface{}{}
+	}
+	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		WeaklyTypedInput: true,
+		Result:           meta,
+		DecodeHook:       stringToFlexibleTimeHook,
+	})
+	if err != nil {
+		return err
+	}
+	if err := dec.Decode(raw); err != nil {
+		return err
+	}
+
+	for k, v := range raw {
+		if !knownMetaKeys[strings.ToLower(k)] {
+			meta.Params[k] = v
+		}
+	}
+	return nil
+}
+
+// mergeLegacyMetaValue folds a single legacy `$key: value` meta line
+// into the same PageMeta struct that front matter decodes into, so
+// existing pages keep working while new ones migrate to fenced front
+// matter. Returns false if mv.Key isn't a recognized legacy key.
+func mergeLegacyMetaValue(meta *PageMeta, mv *MetaValue) bool {
+	switch mv.Key {
+	case "$id":
+		meta.ID = mv.Value
+	case "$soid":
+		meta.SOID = mv.Value
+	case "$search":
+		for _, s := range strings.Split(mv.Value, ",") {
+			meta.Search = append(meta.Search, strings.TrimSpace(s))
+		}
+	case "$score":
+		// ignored, kept only for backwards compatibility with old pages
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecodeWeakMeta(t *testing.T) {
+	raw := map[string]interface{}{
+		"id":      "42",
+		"weight":  "7", // weakly-typed: string -> int
+		"draft":   true,
+		"search":  []interface{}{"foo", "bar"},
+		"date":    "2023-01-15", // bare date, not full RFC3339
+		"unknown": "keep-me",
+	}
+	var meta PageMeta
+	if err := decodeWeakMeta(raw, &meta); err != nil {
+		t.Fatalf("decodeWeakMeta returned error: %s", err)
+	}
+	if meta.ID != "42" {
+		t.Errorf("ID = %q, want \"42\"", meta.ID)
+	}
+	if meta.Weight != 7 {
+		t.Errorf("Weight = %d, want 7", meta.Weight)
+	}
+	if !meta.Draft {
+		t.Errorf("Draft = false, want true")
+	}
+	if len(meta.Search) != 2 || meta.Search[0] != "foo" || meta.Search[1] != "bar" {
+		t.Errorf("Search = %v, want [foo bar]", meta.Search)
+	}
+	want := time.Date(2023, 1, 15, 0, 0, 0, 0, time.UTC)
+	if !meta.Date.Equal(want) {
+		t.Errorf("Date = %v, want %v", meta.Date, want)
+	}
+	if meta.Params["unknown"] != "keep-me" {
+		t.Errorf("Params[unknown] = %v, want \"keep-me\"", meta.Params["unknown"])
+	}
+}
+
+func TestDecodeWeakMetaRFC3339Date(t *testing.T) {
+	raw := map[string]interface{}{"date": "2023-01-15T09:30:00Z"}
+	var meta PageMeta
+	if err := decodeWeakMeta(raw, &meta); err != nil {
+		t.Fatalf("decodeWeakMeta returned error: %s", err)
+	}
+	want := time.Date(2023, 1, 15, 9, 30, 0, 0, time.UTC)
+	if !meta.Date.Equal(want) {
+		t.Errorf("Date = %v, want %v", meta.Date, want)
+	}
+}
+
+func TestDecodeWeakMetaBadDateIsNonFatal(t *testing.T) {
+	raw := map[string]interface{}{"date": "not a date"}
+	var meta PageMeta
+	err := decodeWeakMeta(raw, &meta)
+	if err == nil {
+		t.Fatalf("expected an error for an unparseable date, got nil")
+	}
+}
+
+func TestMergeLegacyMetaValue(t *testing.T) {
+	var meta PageMeta
+
+	if !mergeLegacyMetaValue(&meta, &MetaValue{Key: "$id", Value: "59"}) {
+		t.Fatalf("expected $id to be recognized")
+	}
+	if meta.ID != "59" {
+		t.Errorf("ID = %q, want \"59\"", meta.ID)
+	}
+
+	if !mergeLegacyMetaValue(&meta, &MetaValue{Key: "$search", Value: "a, b ,c"}) {
+		t.Fatalf("expected $search to be recognized")
+	}
+	want := []string{"a", "b", "c"}
+	if len(meta.Search) != len(want) {
+		t.Fatalf("Search = %v, want %v", meta.Search, want)
+	}
+	for i := range want {
+		if meta.Search[i] != want[i] {
+			t.Fatalf("Search = %v, want %v", meta.Search, want)
+		}
+	}
+
+	if !mergeLegacyMetaValue(&meta, &MetaValue{Key: "$score", Value: "ignored"}) {
+		t.Fatalf("expected $score to be recognized (and ignored)")
+	}
+
+	if mergeLegacyMetaValue(&meta, &MetaValue{Key: "$bogus", Value: "x"}) {
+		t.Fatalf("expected an unrecognized key to return false")
+	}
+}
@@ -20,6 +20,10 @@ type Page struct {
 
 	// meta information extracted from page blocks
 	NotionID string
+	// Meta is the structured front matter for this page, decoded from
+	// a fenced ```yaml/toml/json block or, for older pages, assembled
+	// from legacy $key: value lines. See extractMeta.
+	Meta PageMeta
 	// for legacy pages this is an id. Might be used for redirects
 	ID              string
 	StackOverflowID string
@@ -143,9 +147,13 @@ func removeBlocks(page *notionapi.Page, toRemove map[int]bool) {
 }
 
 // extracts PageMeta and updates Block.Content to remove the blocks that
-// contained meta information
+// contained meta information. Front matter (a leading fenced code
+// block) is decoded first, then any remaining legacy $key: value lines
+// are merged into the same p.Meta via mergeLegacyMetaValue.
 func extractMeta(p *Page) {
 	page := p.NotionPage
+	extractFrontMatter(p)
+
 	toRemove := map[int]bool{}
 	for idx, block := range page.Root.Content {
 		mv := extractMetaValueFromBlock(block)
@@ -155,68 +163,78 @@ func extractMeta(p *Page) {
 		toRemove[idx] = true
 		page.Root.Content[idx] = nil
 		// fmt.Printf("'%s' = '%s'\n", mv.Key, mv.Value)
-		switch mv.Key {
-		case "$id":
-			p.ID = mv.Value
-		case "$soid":
-			p.StackOverflowID = mv.Value
-		case "$search":
-			p.Search = strings.Split(mv.Value, ",")
-			for i, s := range p.Search {
-				p.Search[i] = strings.TrimSpace(s)
-			}
-		case "$score":
-			// ignore
-		default:
-			panicIf(true, "unknown key '%s' in page with id %s", mv.Key, normalizeID(page.ID))
+		if mergeLegacyMetaValue(&p.Meta, mv) {
+			continue
 		}
+		panicIf(true, "unknown key '%s' in page with id %s", mv.Key, normalizeID(page.ID))
 	}
 	removeBlocks(page, toRemove)
+
+	// keep the legacy fields in sync until call sites migrate to p.Meta
+	p.ID = p.Meta.ID
+	p.StackOverflowID = p.Meta.SOID
+	p.Search = p.Meta.Search
 }
 
-// https://www.onlinetool.io/gitoembed/widget?url=https%3A%2F%2Fgithub.com%2Fessentialbooks%2Fbooks%2Fblob%2Fmaster%2Fbooks%2Fgo%2F0020-basic-types%2Fbooleans.go
-// to:
-// books/go/0020-basic-types/booleans.go
-// returns empty string if doesn't conform to what we expect
-func gitoembedToRelativePath(uri string) string {
+// extractGitoembedTarget unwraps a
+// https://www.onlinetool.io/gitoembed/widget?url=... embed and returns
+// the URL it actually points at, whatever host that happens to be.
+// gitoembedResolver (resolver.go) uses it to delegate to the rest of
+// the registry.
+func extractGitoembedTarget(uri string) (string, bool) {
 	parsed, err := url.Parse(uri)
 	if err != nil {
-		return ""
+		return "", false
 	}
 	switch parsed.Host {
 	case "www.onlinetool.io", "onlinetool.io":
 		// do nothing
 	default:
-		return ""
+		return "", false
+	}
+	if parsed.Path != "/gitoembed/widget" {
+		return "", false
+	}
+	target := parsed.Query().Get("url")
+	if target == "" {
+		return "", false
+	}
+	return target, true
+}
+
+// readFilteredSourceFileRange reads path like readFilteredSourceFile
+// but, when startLine/endLine come from a parsed #L12-L34 fragment
+// (see parseLineRange), only keeps that inclusive 1-based line range.
+func readFilteredSourceFileRange(path string, startLine, endLine int) ([]string, error) {
+	lines, err := readFilteredSourceFile(path)
+	if err != nil {
+		return nil, err
 	}
-	path := parsed.Path
-	if path != "/gitoembed/widget" {
-		return ""
+	if startLine <= 0 || endLine <= 0 {
+		return lines, nil
 	}
-	uri = parsed.Query().Get("url")
-	// https://github.com/essentialbooks/books/blob/master/books/go/0020-basic-types/booleans.go
-	parsed, err = url.Parse(uri)
-	if parsed.Host != "github.com" {
-		return ""
+	if startLine > endLine {
+		startLine, endLine = endLine, startLine
 	}
-	path = strings.TrimPrefix(parsed.Path, "/essentialbooks/books/")
-	if path == parsed.Path {
-		return ""
+	if startLine > len(lines) {
+		return nil, fmt.Errorf("line range %d-%d out of bounds for '%s' (%d lines)", startLine, endLine, path, len(lines))
 	}
-	// blob/master/books/go/0020-basic-types/booleans.go
-	path = strings.TrimPrefix(path, "blob/")
-	// master/books/go/0020-basic-types/booleans.go
-	// those are branch names. Should I just strip first 2 elements from the path?
-	path = strings.TrimPrefix(path, "master/")
-	path = strings.TrimPrefix(path, "notion/")
-	// books/go/0020-basic-types/booleans.go
-	return path
+	if endLine > len(lines) {
+		endLine = len(lines)
+	}
+	return lines[startLine-1 : endLine], nil
 }
 
-func extractEmbeddedSourceFiles(p *Page) {
+// extractEmbeddedSourceFiles resolves each embed block on the page
+// through DefaultRegistry. A ref that points at a file in this working
+// tree is read straight off disk; anything else is returned as a
+// pending remoteEmbedJob, so bookFromPages can fetch all of them
+// together through a bounded worker pool rather than one page at a time.
+func extractEmbeddedSourceFiles(p *Page) []remoteEmbedJob {
 	wd, err := os.Getwd()
 	panicIfErr(err)
 	page := p.NotionPage
+	var jobs []remoteEmbedJob
 	for _, block := range page.Root.Content {
 		if block.Type != notionapi.BlockEmbed {
 			continue
@@ -226,38 +244,47 @@ func extractEmbeddedSourceFiles(p *Page) {
 			EmbedURL: uri,
 		}
 		p.SourceFiles = append(p.SourceFiles, f)
-		relativePath := gitoembedToRelativePath(uri)
-		if relativePath == "" {
-			fmt.Printf("Couldn't parse embed uri '%s'\n", uri)
+
+		ref, err := DefaultRegistry.Resolve(uri)
+		if err != nil {
+			fmt.Printf("Couldn't parse embed uri '%s': %s\n", uri, err)
+			continue
+		}
+		relativePath, isLocal := ref.LocalPath()
+		if !isLocal {
+			jobs = append(jobs, remoteEmbedJob{f: f, ref: ref})
 			continue
 		}
 		// fmt.Printf("Embed uri: %s, relativePath: %s\n", uri, relativePath)
 		f.FileName = filepath.Base(relativePath)
 		f.Path = filepath.Join(wd, relativePath)
-		f.Lines, err = readFilteredSourceFile(f.Path)
+		f.Lines, err = readFilteredSourceFileRange(f.Path, ref.StartLine, ref.EndLine)
 		if err != nil {
+			f.Err = fmt.Errorf("reading embed %s: %w", uri, err)
 			fmt.Printf("Failed to read '%s' extracted from '%s', error: %s\n", f.Path, uri, err)
 			continue
 		}
 		f.FileExists = true
 	}
+	return jobs
 }
 
-func bookPageFromNotionPage(page *notionapi.Page, pageIDToPage map[string]*notionapi.Page) *Page {
+func bookPageFromNotionPage(page *notionapi.Page, pageIDToPage map[string]*notionapi.Page) (*Page, []remoteEmbedJob) {
 	res := &Page{}
 	res.NotionPage = page
 	res.Title = page.Root.Title
 	extractMeta(res)
-	extractEmbeddedSourceFiles(res)
+	jobs := extractEmbeddedSourceFiles(res)
 	subPages := getSubPages(page, pageIDToPage)
 
 	// fmt.Printf("bookPageFromNotionPage: %s %s\n", normalizeID(page.ID), res.Meta.ID)
 
 	for _, subPage := range subPages {
-		bookPage := bookPageFromNotionPage(subPage, pageIDToPage)
+		bookPage, subJobs := bookPageFromNotionPage(subPage, pageIDToPage)
 		res.Pages = append(res.Pages, bookPage)
+		jobs = append(jobs, subJobs...)
 	}
-	return res
+	return res, jobs
 }
 
 func bookFromPages(book *Book) {
@@ -265,5 +292,8 @@ func bookFromPages(book *Book) {
 	page := book.pageIDToPage[startPageID]
 	panicIf(page.Root.Type != notionapi.BlockPage, "start block is of type '%s' and not '%s'", page.Root.Type, notionapi.BlockPage)
 	book.Title = page.Root.Title
-	book.RootPage = bookPageFromNotionPage(page, book.pageIDToPage)
+	rootPage, jobs := bookPageFromNotionPage(page, book.pageIDToPage)
+	book.RootPage = rootPage
+	fetchRemoteEmbeds(jobs, DefaultRemoteFetchOptions)
+	panicIfErr(runPageGenerators(book))
 }
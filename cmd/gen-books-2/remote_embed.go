@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RemoteFetchOptions controls how remote embeds (code that lives in a
+// different repo, a gist, etc.) are downloaded and cached.
+type RemoteFetchOptions struct {
+	// CacheDir is where downloaded files and their conditional-request
+	// validators (ETag / Last-Modified) are persisted, keyed by the
+	// fetch URL plus ref.
+	CacheDir string
+	// Timeout bounds a single HTTP fetch.
+	Timeout time.Duration
+	// Workers bounds how many fetches run concurrently across all pages.
+	Workers int
+}
+
+// DefaultRemoteFetchOptions is what bookFromPages uses unless a caller
+// overrides it.
+var DefaultRemoteFetchOptions = RemoteFetchOptions{
+	CacheDir: filepath.Join(os.TempDir(), "gen-books-2-embed-cache"),
+	Timeout:  10 * time.Second,
+	Workers:  8,
+}
+
+// remoteEmbedJob is a pending remote fetch discovered while extracting
+// a page's embeds: ref came out of DefaultRegistry.Resolve and didn't
+// resolve to a file in this working tree. Jobs from every page are
+// collected by bookFromPages and drained together by fetchRemoteEmbeds,
+// so fetches are batched across the whole book instead of serialized
+// page by page.
+type remoteEmbedJob struct {
+	f   *EmbeddedSourceFile
+	ref RemoteRef
+}
+
+// remoteCacheEntry is the on-disk sidecar recording a fetch's
+// conditional-request validators, so the next build sends
+// If-None-Match / If-Modified-Since and can skip the download on 304.
+type remoteCacheEntry struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+func remoteCacheKey(rawURL, ref string) string {
+	h := sha256.Sum256([]byte(rawURL + "@" + ref))
+	return hex.EncodeToString(h[:])
+}
+
+func remoteCachePaths(dir, key string) (contentPath, metaPath string) {
+	return filepath.Join(dir, key+".content"), filepath.Join(dir, key+".json")
+}
+
+// fetchRemoteFile downloads rawURL into opts.CacheDir and returns the
+// path of the cached content, so callers can feed it straight into
+// readFilteredSourceFile like any other file on disk. On a network
+// error it falls back to whatever is already cached rather than
+// failing the fetch outright.
+func fetchRemoteFile(rawURL, ref string, opts RemoteFetchOptions) (string, error) {
+	if err := os.MkdirAll(opts.CacheDir, 0755); err != nil {
+		return "", err
+	}
+	key := remoteCacheKey(rawURL, ref)
+	contentPath, metaPath := remoteCachePaths(opts.CacheDir, key)
+
+	var entry remoteCacheEntry
+	if data, err := ioutil.ReadFile(metaPath); err == nil {
+		_ = json.Unmarshal(data, &entry)
+	}
+
+	client := &http.Client{Timeout: opts.Timeout}
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	if entry.ETag != "" {
+		req.Header.Set("If-None-Match", entry.ETag)
+	}
+	if entry.LastModified != "" {
+		req.Header.Set("If-Modified-Since", entry.LastModified)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if _, statErr := os.Stat(contentPath); statErr == nil {
+			return contentPath, nil
+		}
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return contentPath, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching %s: unexpected status %s", rawURL, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(contentPath, body, 0644); err != nil {
+		return "", err
+	}
+	entry = remoteCacheEntry{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	if data, err := json.Marshal(entry); err == nil {
+		_ = ioutil.WriteFile(metaPath, data, 0644)
+	}
+	return contentPath, nil
+}
+
+// fetchRemoteEmbeds drains jobs through a bounded pool of opts.Workers
+// goroutines so a book with many remote embeds doesn't serialize on
+// network round trips. Each job sets f.Lines/f.FileExists on success
+// or f.Err on failure; a broken remote embed never panics the build,
+// it just leaves that one EmbeddedSourceFile in an error state for the
+// template to handle (`{{ with .Err }}`).
+func fetchRemoteEmbeds(jobs []remoteEmbedJob, opts RemoteFetchOptions) {
+	if len(jobs) == 0 {
+		return
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+
+	jobCh := make(chan remoteEmbedJob)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobCh {
+				rawURL := job.ref.RawURL()
+				if rawURL == "" {
+					if !job.ref.SkipFetch {
+						job.f.Err = fmt.Errorf("resolver for host '%s' produced a ref with no FetchURL", job.ref.Host)
+					}
+					// SkipFetch means the resolver deliberately left
+					// nothing to download (e.g. a carbon.now.sh
+					// permalink), so leave it for the template to
+					// render as an image embed instead.
+					continue
+				}
+				path, err := fetchRemoteFile(rawURL, job.ref.Ref, opts)
+				if err != nil {
+					job.f.Err = fmt.Errorf("fetching embed %s: %w", rawURL, err)
+					continue
+				}
+				lines, err := readFilteredSourceFileRange(path, job.ref.StartLine, job.ref.EndLine)
+				if err != nil {
+					job.f.Err = fmt.Errorf("reading fetched embed %s: %w", rawURL, err)
+					continue
+				}
+				job.f.Path = path
+				job.f.Lines = lines
+				job.f.FileExists = true
+			}
+		}()
+	}
+	for _, job := range jobs {
+		jobCh <- job
+	}
+	close(jobCh)
+	wg.Wait()
+}
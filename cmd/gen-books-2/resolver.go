@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RemoteRef is what an EmbedResolver reduces an embed URL to: enough to
+// either read the file straight out of this working tree (LocalPath)
+// or fetch it from the network (RawURL). The resolver that produced
+// the ref is the one that fills in FetchURL/LocalRelPath/SkipFetch -
+// RemoteRef itself has no built-in notion of which hosts are fetchable
+// or local, so a resolver registered via RegisterResolver for a private
+// host works identically to a built-in one.
+type RemoteRef struct {
+	Host string
+	// Owner/Repo/Ref/Path follow the usual "blob" URL shape
+	// (host/owner/repo/blob/ref/path...). RawURL and LocalPath don't
+	// derive anything from them; a resolver that needs them builds
+	// FetchURL/LocalRelPath from them itself. Resolvers for
+	// things that don't have that shape (carbon.now.sh, the Go
+	// playground) are free to leave them unused.
+	Owner string
+	Repo  string
+	Ref   string
+	Path  string
+
+	// StartLine/EndLine come from a #L12-L34 style fragment; 0 means
+	// "not specified" i.e. the whole file.
+	StartLine int
+	EndLine   int
+
+	// FetchURL is the directly-downloadable URL for this ref, computed
+	// by the resolver that produced it. Leave empty only when SkipFetch
+	// is also set, meaning there's deliberately nothing to download.
+	FetchURL string
+	// SkipFetch marks a ref the resolver knows can't be fetched as
+	// source (e.g. a carbon.now.sh permalink, which renders as an
+	// image, not text) - as opposed to an empty FetchURL left by a
+	// resolver that forgot to set one, which fetchRemoteEmbeds reports
+	// as an error instead of silently skipping.
+	SkipFetch bool
+	// LocalRelPath, when non-empty, means this ref is a file already in
+	// the current working tree and should be read from that relative
+	// path instead of fetched.
+	LocalRelPath string
+}
+
+// LocalPath returns the path of this ref relative to the working tree,
+// for a ref whose resolver determined it's a file already in this repo
+// (see LocalRelPath).
+func (r RemoteRef) LocalPath() (string, bool) {
+	return r.LocalRelPath, r.LocalRelPath != ""
+}
+
+// RawURL returns the directly-downloadable URL for this ref, as set by
+// the resolver that produced it.
+func (r RemoteRef) RawURL() string {
+	return r.FetchURL
+}
+
+// EmbedResolver recognizes one flavor of "link to a source file" embed
+// URL and reduces it to a RemoteRef.
+type EmbedResolver interface {
+	Match(uri string) bool
+	Resolve(uri string) (RemoteRef, error)
+}
+
+// ResolverRegistry is an ordered list of EmbedResolvers tried in
+// registration order; the first one whose Match returns true handles
+// the URL.
+type ResolverRegistry struct {
+	mu        sync.Mutex
+	resolvers []EmbedResolver
+}
+
+// DefaultRegistry is the registry extractEmbeddedSourceFiles walks.
+// main can call RegisterResolver to add support for private hosts
+// (a self-hosted Gitea instance, say) without touching this file.
+var DefaultRegistry = newDefaultRegistry()
+
+func newDefaultRegistry() *ResolverRegistry {
+	reg := &ResolverRegistry{}
+	reg.Register(gitoembedResolver{})
+	reg.Register(blobResolver{})
+	reg.Register(gistResolver{})
+	reg.Register(carbonResolver{})
+	reg.Register(playgroundResolver{})
+	return reg
+}
+
+// Register adds res to the registry, to be tried after every resolver
+// already registered.
+func (reg *ResolverRegistry) Register(res EmbedResolver) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.resolvers = append(reg.resolvers, res)
+}
+
+// Resolve tries each registered resolver in turn and returns the first
+// match. Returns an error if no resolver recognizes uri.
+func (reg *ResolverRegistry) Resolve(uri string) (RemoteRef, error) {
+	reg.mu.Lock()
+	resolvers := append([]EmbedResolver(nil), reg.resolvers...)
+	reg.mu.Unlock()
+
+	for _, res := range resolvers {
+		if res.Match(uri) {
+			return res.Resolve(uri)
+		}
+	}
+	return RemoteRef{}, fmt.Errorf("no EmbedResolver recognizes '%s'", uri)
+}
+
+// RegisterResolver adds res to DefaultRegistry. Call this from main to
+// teach gen-books-2 about a host the built-in resolvers don't cover.
+func RegisterResolver(res EmbedResolver) {
+	DefaultRegistry.Register(res)
+}
+
+var lineRangeRe = regexp.MustCompile(`^L(\d+)(?:-L?(\d+))?$`)
+
+// parseLineRange decodes a URL fragment like "L12-L34" or "L12" into a
+// 1-based [start, end] line range. Returns (0, 0) if fragment doesn't
+// match, meaning "whole file". A reversed range (e.g. from a
+// bottom-to-top selection, "L34-L12") is swapped rather than left to
+// produce an invalid range downstream.
+func parseLineRange(fragment string) (start, end int) {
+	m := lineRangeRe.FindStringSubmatch(fragment)
+	if m == nil {
+		return 0, 0
+	}
+	start, _ = strconv.Atoi(m[1])
+	if m[2] == "" {
+		return start, start
+	}
+	end, _ = strconv.Atoi(m[2])
+	if start > end {
+		start, end = end, start
+	}
+	return start, end
+}
+
+// blobResolver handles raw "view this file" blob URLs on github.com,
+// gitlab.com and bitbucket.org:
+//
+//	https://github.com/owner/repo/blob/ref/path/to/file.go#L12-L34
+//	https://gitlab.com/owner/repo/-/blob/ref/path/to/file.go
+//	https://bitbucket.org/owner/repo/src/ref/path/to/file.go
+type blobResolver struct{}
+
+var blobPathRes = map[string]*regexp.Regexp{
+	"github.com":    regexp.MustCompile(`^/([^/]+)/([^/]+)/blob/([^/]+)/(.+)$`),
+	"gitlab.com":    regexp.MustCompile(`^/([^/]+)/([^/]+)/-/blob/([^/]+)/(.+)$`),
+	"bitbucket.org": regexp.MustCompile(`^/([^/]+)/([^/]+)/src/([^/]+)/(.+)$`),
+}
+
+func (blobResolver) Match(uri string) bool {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	re, ok := blobPathRes[parsed.Host]
+	return ok && re.MatchString(parsed.Path)
+}
+
+func (blobResolver) Resolve(uri string) (RemoteRef, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return RemoteRef{}, err
+	}
+	re := blobPathRes[parsed.Host]
+	m := re.FindStringSubmatch(parsed.Path)
+	if m == nil {
+		return RemoteRef{}, fmt.Errorf("'%s' doesn't look like a %s blob URL", uri, parsed.Host)
+	}
+	ref := RemoteRef{Host: parsed.Host, Owner: m[1], Repo: m[2], Ref: m[3], Path: m[4]}
+	ref.StartLine, ref.EndLine = parseLineRange(parsed.Fragment)
+
+	if ref.Host == "github.com" && ref.Owner == "essentialbooks" && ref.Repo == "books" {
+		ref.LocalRelPath = ref.Path
+		return ref, nil
+	}
+	switch ref.Host {
+	case "github.com":
+		ref.FetchURL = fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s/%s", ref.Owner, ref.Repo, ref.Ref, ref.Path)
+	case "gitlab.com":
+		ref.FetchURL = fmt.Sprintf("https://gitlab.com/%s/%s/-/raw/%s/%s", ref.Owner, ref.Repo, ref.Ref, ref.Path)
+	case "bitbucket.org":
+		ref.FetchURL = fmt.Sprintf("https://bitbucket.org/%s/%s/raw/%s/%s", ref.Owner, ref.Repo, ref.Ref, ref.Path)
+	}
+	return ref, nil
+}
+
+// gistResolver handles https://gist.github.com/owner/gistid URLs,
+// optionally with a #file-name.go fragment picking one file out of a
+// multi-file gist.
+type gistResolver struct{}
+
+var gistPathRe = regexp.MustCompile(`^/([^/]+)/([0-9a-fA-F]+)/?$`)
+
+func (gistResolver) Match(uri string) bool {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	return parsed.Host == "gist.github.com" && gistPathRe.MatchString(parsed.Path)
+}
+
+func (gistResolver) Resolve(uri string) (RemoteRef, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return RemoteRef{}, err
+	}
+	m := gistPathRe.FindStringSubmatch(parsed.Path)
+	if m == nil {
+		return RemoteRef{}, fmt.Errorf("'%s' doesn't look like a gist URL", uri)
+	}
+	ref := RemoteRef{Host: "gist.github.com", Owner: m[1], Repo: m[2]}
+	if frag := strings.TrimPrefix(parsed.Fragment, "file-"); frag != parsed.Fragment {
+		ref.Path = frag
+	}
+	// Repo holds the gist id, Path (if any) picks one file out of a
+	// multi-file gist.
+	if ref.Path == "" {
+		ref.FetchURL = fmt.Sprintf("https://gist.github.com/%s/%s/raw", ref.Owner, ref.Repo)
+	} else {
+		ref.FetchURL = fmt.Sprintf("https://gist.github.com/%s/%s/raw/%s", ref.Owner, ref.Repo, ref.Path)
+	}
+	return ref, nil
+}
+
+// carbonResolver handles carbon.now.sh permalinks
+// (https://carbon.now.sh/<share-id>). carbon.now.sh itself has no
+// "download the source" endpoint: the snippet is embedded in the page
+// as a query parameter once you resolve the short permalink. We store
+// the permalink verbatim in Path and mark the ref SkipFetch so
+// fetchRemoteEmbeds leaves it alone instead of treating the missing
+// FetchURL as an error; Err is left unset so `{{ with .Err }}`
+// templates keep showing the embed as a best-effort fallback image.
+type carbonResolver struct{}
+
+func (carbonResolver) Match(uri string) bool {
+	parsed, err := url.Parse(uri)
+	return err == nil && parsed.Host == "carbon.now.sh"
+}
+
+func (carbonResolver) Resolve(uri string) (RemoteRef, error) {
+	return RemoteRef{Host: "carbon.now.sh", Path: uri, SkipFetch: true}, nil
+}
+
+// playgroundResolver handles Go playground share links
+// (https://play.golang.org/p/<id>), which can be downloaded as plain
+// source by appending ".go".
+type playgroundResolver struct{}
+
+var playgroundPathRe = regexp.MustCompile(`^/p/([0-9A-Za-z_-]+)/?$`)
+
+func (playgroundResolver) Match(uri string) bool {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	return (parsed.Host == "play.golang.org" || parsed.Host == "go.dev") && playgroundPathRe.MatchString(parsed.Path)
+}
+
+func (playgroundResolver) Resolve(uri string) (RemoteRef, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return RemoteRef{}, err
+	}
+	m := playgroundPathRe.FindStringSubmatch(parsed.Path)
+	if m == nil {
+		return RemoteRef{}, fmt.Errorf("'%s' doesn't look like a Go playground share URL", uri)
+	}
+	id := m[1]
+	return RemoteRef{
+		Host:     "play.golang.org",
+		Repo:     id,
+		FetchURL: fmt.Sprintf("https://play.golang.org/p/%s.go", id),
+	}, nil
+}
+
+// gitoembedResolver unwraps a
+// https://www.onlinetool.io/gitoembed/widget?url=... embed and
+// delegates resolution of the URL it actually points at to the rest of
+// the registry, so e.g. a gitoembed-wrapped gitlab blob URL works
+// without this resolver knowing anything about gitlab.
+type gitoembedResolver struct{}
+
+func (gitoembedResolver) Match(uri string) bool {
+	_, ok := extractGitoembedTarget(uri)
+	return ok
+}
+
+func (gitoembedResolver) Resolve(uri string) (RemoteRef, error) {
+	target, ok := extractGitoembedTarget(uri)
+	if !ok {
+		return RemoteRef{}, fmt.Errorf("'%s' isn't a gitoembed widget URL", uri)
+	}
+	return DefaultRegistry.Resolve(target)
+}
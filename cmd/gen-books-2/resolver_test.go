@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseLineRange(t *testing.T) {
+	cases := []struct {
+		fragment   string
+		start, end int
+	}{
+		{"", 0, 0},
+		{"L12", 12, 12},
+		{"L12-L34", 12, 34},
+		{"L34-L12", 12, 34}, // reversed range is swapped, not left invalid
+		{"not-a-line-range", 0, 0},
+	}
+	for _, c := range cases {
+		start, end := parseLineRange(c.fragment)
+		if start != c.start || end != c.end {
+			t.Errorf("parseLineRange(%q) = (%d, %d), want (%d, %d)", c.fragment, start, end, c.start, c.end)
+		}
+	}
+}
+
+func TestBlobResolverResolve(t *testing.T) {
+	ref, err := blobResolver{}.Resolve("https://github.com/essentialbooks/books/blob/master/books/go/0020-basic-types/booleans.go#L34-L12")
+	if err != nil {
+		t.Fatalf("Resolve returned error: %s", err)
+	}
+	if relPath, ok := ref.LocalPath(); !ok || relPath != "books/go/0020-basic-types/booleans.go" {
+		t.Errorf("LocalPath() = (%q, %v), want local essentialbooks/books path", relPath, ok)
+	}
+	if ref.StartLine != 12 || ref.EndLine != 34 {
+		t.Errorf("got StartLine=%d EndLine=%d, want 12, 34 (swapped)", ref.StartLine, ref.EndLine)
+	}
+}
+
+func TestBlobResolverResolveRemoteRepo(t *testing.T) {
+	ref, err := blobResolver{}.Resolve("https://github.com/someone/otherrepo/blob/main/main.go")
+	if err != nil {
+		t.Fatalf("Resolve returned error: %s", err)
+	}
+	if _, ok := ref.LocalPath(); ok {
+		t.Errorf("expected a non-essentialbooks/books ref to not be local")
+	}
+	want := "https://raw.githubusercontent.com/someone/otherrepo/main/main.go"
+	if ref.RawURL() != want {
+		t.Errorf("RawURL() = %q, want %q", ref.RawURL(), want)
+	}
+}
+
+func TestCarbonResolverSkipsFetchWithoutError(t *testing.T) {
+	ref, err := carbonResolver{}.Resolve("https://carbon.now.sh/abc123")
+	if err != nil {
+		t.Fatalf("Resolve returned error: %s", err)
+	}
+	if ref.RawURL() != "" || !ref.SkipFetch {
+		t.Errorf("expected an empty RawURL with SkipFetch set, got RawURL=%q SkipFetch=%v", ref.RawURL(), ref.SkipFetch)
+	}
+}
+
+func TestReadFilteredSourceFileRangeSwapsReversedRange(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sample.go")
+	content := "one\ntwo\nthree\nfour\nfive\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+
+	// a reversed range (start > end) must not panic.
+	lines, err := readFilteredSourceFileRange(path, 4, 2)
+	if err != nil {
+		t.Fatalf("readFilteredSourceFileRange returned error: %s", err)
+	}
+	want := []string{"two", "three", "four"}
+	if len(lines) != len(want) {
+		t.Fatalf("got %v, want %v", lines, want)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Fatalf("got %v, want %v", lines, want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gorilla/websocket"
+)
+
+// Watcher watches every file backing an EmbeddedSourceFile and, on a
+// change, re-renders just the Pages that embed it instead of the whole
+// book, then pushes a livereload event to any connected browser. It's
+// only started for the dev server, never for a one-shot build.
+type Watcher struct {
+	book *Book
+	fsw  *fsnotify.Watcher
+
+	mu           sync.Mutex
+	pathToPages  map[string][]*Page
+	pending      map[string]bool
+	pendingTimer *time.Timer
+	debounce     time.Duration
+
+	clientsMu sync.Mutex
+	clients   map[*websocket.Conn]bool
+}
+
+var watcherUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// NewWatcher indexes every EmbeddedSourceFile.Path under book's page
+// tree and starts watching it for changes. It watches each file's
+// containing directory rather than the file itself: an editor that
+// saves atomically (write a temp file, then rename it over the
+// original - vim, gofmt -w, most IDEs) replaces the inode a
+// file-level watch is bound to, so after the first save the watch goes
+// silent. Hugo's dev server avoids this the same way. Events are then
+// filtered down to the paths we actually care about in loop. Call
+// Close to stop.
+func NewWatcher(book *Book) (*Watcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		book:        book,
+		fsw:         fsw,
+		pathToPages: indexEmbeddedFilesByPath(book.RootPage),
+		pending:     map[string]bool{},
+		debounce:    300 * time.Millisecond,
+		clients:     map[*websocket.Conn]bool{},
+	}
+	dirs := map[string]bool{}
+	for path := range w.pathToPages {
+		dirs[filepath.Dir(path)] = true
+	}
+	for dir := range dirs {
+		if err := fsw.Add(dir); err != nil {
+			fmt.Printf("Watcher: failed to watch '%s': %s\n", dir, err)
+		}
+	}
+	go w.loop()
+	return w, nil
+}
+
+// indexEmbeddedFilesByPath walks the page tree and returns, for every
+// file backing an EmbeddedSourceFile, the set of pages that embed it -
+// usually just one, but the same source file can be embedded from
+// several pages.
+func indexEmbeddedFilesByPath(root *Page) map[string][]*Page {
+	idx := map[string][]*Page{}
+	var walk func(p *Page)
+	walk = func(p *Page) {
+		for _, f := range p.SourceFiles {
+			if f.Path == "" {
+				continue
+			}
+			idx[f.Path] = append(idx[f.Path], p)
+		}
+		for _, sub := range p.Pages {
+			walk(sub)
+		}
+	}
+	walk(root)
+	return idx
+}
+
+func (w *Watcher) loop() {
+	for {
+		select {
+		case ev, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			if ev.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			// Watching the directory means we see every file in it;
+			// only react to the ones we actually index.
+			if _, tracked := w.pathToPages[ev.Name]; !tracked {
+				continue
+			}
+			w.queue(ev.Name)
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			fmt.Printf("Watcher error: %s\n", err)
+		}
+	}
+}
+
+// queue debounces a burst of events on a short window the way Hugo's
+// dev server does, so an editor that writes a file out in several
+// syscalls only triggers one rebuild.
+func (w *Watcher) queue(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending[path] = true
+	if w.pendingTimer != nil {
+		w.pendingTimer.Stop()
+	}
+	w.pendingTimer = time.AfterFunc(w.debounce, w.flush)
+}
+
+func (w *Watcher) flush() {
+	w.mu.Lock()
+	paths := make([]string, 0, len(w.pending))
+	for path := range w.pending {
+		paths = append(paths, path)
+	}
+	w.pending = map[string]bool{}
+	w.mu.Unlock()
+
+	if len(paths) == 0 {
+		return
+	}
+
+	changed := map[*Page]bool{}
+	for _, path := range paths {
+		for _, p := range w.pathToPages[path] {
+			changed[p] = true
+		}
+	}
+	for p := range changed {
+		if err := rerenderPage(p); err != nil {
+			fmt.Printf("Watcher: failed to re-render '%s': %s\n", p.Title, err)
+		}
+	}
+	w.broadcastReload(pickOneWriteOrCreatePath(paths, w.pathToPages))
+}
+
+// rerenderPage re-runs the embed-reading and HTML-rendering steps for a
+// single page: the targeted equivalent of what a full build does for
+// every page.
+func rerenderPage(p *Page) error {
+	p.SourceFiles = nil
+	jobs := extractEmbeddedSourceFiles(p)
+	fetchRemoteEmbeds(jobs, DefaultRemoteFetchOptions)
+	return renderPageHTML(p)
+}
+
+// pickOneWriteOrCreatePath picks a single path out of a debounced batch
+// of changed files to treat as "the one the user is currently editing"
+// - whichever path backs a known page and was modified most recently -
+// so the browser can auto-navigate there after a livereload.
+func pickOneWriteOrCreatePath(paths []string, pathToPages map[string][]*Page) string {
+	var best string
+	var bestMod time.Time
+	for _, path := range paths {
+		if _, ok := pathToPages[path]; !ok {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(bestMod) {
+			best = path
+			bestMod = info.ModTime()
+		}
+	}
+	return best
+}
+
+// reloadMessage is pushed to every connected browser over the
+// livereload websocket after a debounced batch of changes has been
+// re-rendered.
+type reloadMessage struct {
+	// Path is the file pickOneWriteOrCreatePath chose, so the browser
+	// can auto-navigate to the page that embeds it.
+	Path string `json:"path"`
+}
+
+func (w *Watcher) broadcastReload(changedPath string) {
+	msg := reloadMessage{Path: changedPath}
+	w.clientsMu.Lock()
+	defer w.clientsMu.Unlock()
+	for conn := range w.clients {
+		if err := conn.WriteJSON(msg); err != nil {
+			conn.Close()
+			delete(w.clients, conn)
+		}
+	}
+}
+
+// ServeHTTP upgrades a request to the livereload websocket; register
+// it alongside the rest of the dev server's handlers, e.g.
+// mux.Handle("/livereload", watcher).
+func (w *Watcher) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	conn, err := watcherUpgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		return
+	}
+	w.clientsMu.Lock()
+	w.clients[conn] = true
+	w.clientsMu.Unlock()
+}
+
+// Close stops watching and releases the underlying fsnotify watcher.
+func (w *Watcher) Close() error {
+	return w.fsw.Close()
+}